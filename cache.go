@@ -0,0 +1,265 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults for the on-disk cache, overridable via CACHE_MAX_SIZE_BYTES and
+// CACHE_MAX_AGE.
+const (
+	defaultCacheMaxSize = 500 * 1024 * 1024 // 500 MiB
+	defaultCacheMaxAge  = 24 * time.Hour
+)
+
+// CacheStats summarizes the current state of a DiskCache, returned by the
+// admin /api/cache endpoint.
+type CacheStats struct {
+	Entries   int   `json:"entries"`
+	TotalSize int64 `json:"totalSizeBytes"`
+	MaxSize   int64 `json:"maxSizeBytes"`
+}
+
+// metaSuffix names the sidecar file a cache entry's Meta is persisted under,
+// so ContentType/ContentEncoding survive a restart alongside the entry
+// itself. rebuildIndex skips files with this suffix when it walks dir.
+const metaSuffix = ".meta.json"
+
+// diskCacheEntry tracks one on-disk cache file plus enough bookkeeping to
+// support TTL expiry and LRU eviction. key is the hashed disk name (see
+// hashKey), never the caller-supplied cache key.
+type diskCacheEntry struct {
+	key       string
+	size      int64
+	createdAt time.Time
+	meta      Meta
+	elem      *list.Element // this entry's node in lru, front = most recently used
+}
+
+// hashKey maps a caller-supplied cache key (e.g. resizeImage's
+// "filename_width_height_..." string, built from unauthenticated query
+// params) to the name it's stored under on disk. Get/Set must never join a
+// caller-supplied key into a filesystem path directly: width/height/path/
+// format all flow into that key unsanitized, and a key like
+// "../../etc/cron.d/evil" would otherwise escape the cache directory.
+// Hashing collapses every key to a fixed-length hex string with no path
+// separators, so the on-disk name is always confined to dir.
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// DiskCache is a two-tier cache: an in-memory index of what's on disk, plus
+// the on-disk cache directory itself. It enforces a total size cap (evicting
+// least-recently-used entries first) and a per-entry TTL, and rebuilds its
+// index from the cache directory on startup so a restart doesn't forget
+// what's already cached.
+type DiskCache struct {
+	mu      sync.Mutex
+	dir     string
+	maxSize int64
+	maxAge  time.Duration
+
+	index     map[string]*diskCacheEntry
+	lru       *list.List
+	totalSize int64
+}
+
+// NewDiskCache creates a DiskCache rooted at dir, creating it if needed, and
+// rebuilds its index from any files already present.
+func NewDiskCache(dir string, maxSize int64, maxAge time.Duration) (*DiskCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("creating cache dir %s: %w", dir, err)
+	}
+
+	c := &DiskCache{
+		dir:     dir,
+		maxSize: maxSize,
+		maxAge:  maxAge,
+		index:   make(map[string]*diskCacheEntry),
+		lru:     list.New(),
+	}
+	if err := c.rebuildIndex(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// rebuildIndex walks dir and registers every file already there, so cache
+// occupancy survives a restart. Entries are inserted into the LRU list
+// oldest-mtime-first, so the rebuilt ordering reflects actual modification
+// time instead of os.ReadDir's alphabetical-by-hashed-name order — otherwise
+// eviction after a restart would be driven by hash-string sort order rather
+// than recency.
+func (c *DiskCache) rebuildIndex() error {
+	des, err := os.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+
+	type found struct {
+		name    string
+		size    int64
+		modTime time.Time
+	}
+	var entries []found
+	for _, de := range des {
+		if de.IsDir() || strings.HasSuffix(de.Name(), metaSuffix) {
+			continue
+		}
+		info, err := de.Info()
+		if err != nil {
+			continue
+		}
+		entries = append(entries, found{name: de.Name(), size: info.Size(), modTime: info.ModTime()})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].modTime.Before(entries[j].modTime) })
+
+	for _, e := range entries {
+		c.track(e.name, e.size, e.modTime, c.readMeta(e.name))
+	}
+	return nil
+}
+
+// track registers a cache entry as most-recently-used. Callers must hold c.mu
+// except when called from rebuildIndex during construction.
+func (c *DiskCache) track(key string, size int64, createdAt time.Time, meta Meta) {
+	entry := &diskCacheEntry{key: key, size: size, createdAt: createdAt, meta: meta}
+	entry.elem = c.lru.PushFront(entry)
+	c.index[key] = entry
+	c.totalSize += size
+}
+
+// path returns the on-disk path for diskName, which must already be a
+// hashed name (see hashKey) — never a raw caller-supplied key.
+func (c *DiskCache) path(diskName string) string {
+	return filepath.Join(c.dir, diskName)
+}
+
+func (c *DiskCache) metaPath(diskName string) string {
+	return filepath.Join(c.dir, diskName+metaSuffix)
+}
+
+// readMeta loads the sidecar Meta for diskName, returning a zero Meta if
+// it's missing or unreadable (e.g. an entry written before Meta was
+// persisted).
+func (c *DiskCache) readMeta(diskName string) Meta {
+	data, err := os.ReadFile(c.metaPath(diskName))
+	if err != nil {
+		return Meta{}
+	}
+	var meta Meta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return Meta{}
+	}
+	return meta
+}
+
+// Get returns the cached bytes and Meta for key, or ok=false if the key is
+// missing or has expired. A hit moves key to the front of the LRU list (most
+// recently used, least likely to be evicted next).
+func (c *DiskCache) Get(key string) (r io.ReadCloser, meta Meta, ok bool) {
+	diskName := hashKey(key)
+
+	c.mu.Lock()
+	entry, found := c.index[diskName]
+	if !found {
+		c.mu.Unlock()
+		return nil, Meta{}, false
+	}
+	if c.maxAge > 0 && time.Since(entry.createdAt) > c.maxAge {
+		c.removeLocked(entry)
+		c.mu.Unlock()
+		return nil, Meta{}, false
+	}
+	c.lru.MoveToFront(entry.elem)
+	meta = entry.meta
+	c.mu.Unlock()
+
+	f, err := os.Open(c.path(diskName))
+	if err != nil {
+		return nil, Meta{}, false
+	}
+	return f, meta, true
+}
+
+// Set writes data and meta under key, evicting least-recently-used entries
+// first if the cache would otherwise exceed maxSize.
+func (c *DiskCache) Set(key string, data []byte, meta Meta) error {
+	diskName := hashKey(key)
+
+	if err := os.WriteFile(c.path(diskName), data, 0644); err != nil {
+		return err
+	}
+	encodedMeta, err := json.Marshal(meta)
+	if err != nil {
+		return fmt.Errorf("encoding cache meta for %s: %w", key, err)
+	}
+	if err := os.WriteFile(c.metaPath(diskName), encodedMeta, 0644); err != nil {
+		return fmt.Errorf("writing cache meta for %s: %w", key, err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if old, found := c.index[diskName]; found {
+		c.removeLocked(old)
+	}
+	c.track(diskName, int64(len(data)), time.Now(), meta)
+	c.evictLocked()
+	return nil
+}
+
+// evictLocked removes least-recently-used entries until the cache is back
+// under maxSize. Callers must hold c.mu.
+func (c *DiskCache) evictLocked() {
+	if c.maxSize <= 0 {
+		return
+	}
+	for c.totalSize > c.maxSize {
+		back := c.lru.Back()
+		if back == nil {
+			return
+		}
+		c.removeLocked(back.Value.(*diskCacheEntry))
+	}
+}
+
+// removeLocked deletes entry's file and its index/LRU bookkeeping. Callers
+// must hold c.mu.
+func (c *DiskCache) removeLocked(entry *diskCacheEntry) {
+	os.Remove(c.path(entry.key))
+	os.Remove(c.metaPath(entry.key))
+	c.lru.Remove(entry.elem)
+	delete(c.index, entry.key)
+	c.totalSize -= entry.size
+}
+
+// Purge removes every entry from the cache.
+func (c *DiskCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, entry := range c.index {
+		os.Remove(c.path(entry.key))
+		os.Remove(c.metaPath(entry.key))
+	}
+	c.index = make(map[string]*diskCacheEntry)
+	c.lru.Init()
+	c.totalSize = 0
+}
+
+// Stats reports the cache's current size and entry count.
+func (c *DiskCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return CacheStats{Entries: len(c.index), TotalSize: c.totalSize, MaxSize: c.maxSize}
+}
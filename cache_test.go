@@ -0,0 +1,170 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDiskCacheSetGetRoundTrip(t *testing.T) {
+	c, err := NewDiskCache(t.TempDir(), defaultCacheMaxSize, defaultCacheMaxAge)
+	if err != nil {
+		t.Fatalf("NewDiskCache: %v", err)
+	}
+
+	meta := Meta{ContentType: "image/webp", ContentEncoding: "gzip"}
+	if err := c.Set("key", []byte("hello"), meta); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	rc, gotMeta, ok := c.Get("key")
+	if !ok {
+		t.Fatal("Get: expected hit after Set")
+	}
+	defer rc.Close()
+
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("reading cached data: %v", err)
+	}
+	if string(data) != "hello" {
+		t.Errorf("cached data = %q, want %q", data, "hello")
+	}
+	if gotMeta != meta {
+		t.Errorf("cached meta = %+v, want %+v", gotMeta, meta)
+	}
+
+	if _, _, ok := c.Get("missing"); ok {
+		t.Error("Get(\"missing\"): expected miss")
+	}
+}
+
+func TestDiskCacheTTLExpiry(t *testing.T) {
+	c, err := NewDiskCache(t.TempDir(), defaultCacheMaxSize, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("NewDiskCache: %v", err)
+	}
+
+	if err := c.Set("key", []byte("hello"), Meta{}); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+
+	if _, _, ok := c.Get("key"); ok {
+		t.Error("Get: expected miss after maxAge elapsed")
+	}
+}
+
+func TestDiskCacheLRUEviction(t *testing.T) {
+	// Each entry below is 1 byte, so a 2-byte cap holds exactly two entries.
+	c, err := NewDiskCache(t.TempDir(), 2, 0)
+	if err != nil {
+		t.Fatalf("NewDiskCache: %v", err)
+	}
+
+	c.Set("a", []byte("1"), Meta{})
+	c.Set("b", []byte("2"), Meta{})
+	// Touch "a" so it's more recently used than "b".
+	if _, _, ok := c.Get("a"); !ok {
+		t.Fatal("Get(\"a\"): expected hit before eviction")
+	}
+	c.Set("c", []byte("3"), Meta{})
+
+	if _, _, ok := c.Get("b"); ok {
+		t.Error("Get(\"b\"): expected eviction as the least-recently-used entry")
+	}
+	if rc, _, ok := c.Get("a"); !ok {
+		t.Error("Get(\"a\"): expected to survive eviction as the most-recently-used entry")
+	} else {
+		rc.Close()
+	}
+	if rc, _, ok := c.Get("c"); !ok {
+		t.Error("Get(\"c\"): expected the just-written entry to be present")
+	} else {
+		rc.Close()
+	}
+
+	if stats := c.Stats(); stats.Entries != 2 {
+		t.Errorf("Stats().Entries = %d, want 2", stats.Entries)
+	}
+}
+
+// TestDiskCacheKeyIsHashedNotJoined guards against path traversal: a cache
+// key built from unauthenticated query params (resizeImage's
+// "filename_width_height_path_format_gzip") must never be joined into a
+// filesystem path raw, or a key like "../../etc/cron.d/evil" escapes dir.
+func TestDiskCacheKeyIsHashedNotJoined(t *testing.T) {
+	dir := t.TempDir()
+	c, err := NewDiskCache(dir, defaultCacheMaxSize, 0)
+	if err != nil {
+		t.Fatalf("NewDiskCache: %v", err)
+	}
+
+	maliciousKey := "evil.jpg_../../../../tmp/pwned_300_300__false"
+	if err := c.Set(maliciousKey, []byte("payload"), Meta{}); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir: %v", err)
+	}
+	for _, de := range entries {
+		if strings.Contains(de.Name(), "..") || strings.ContainsRune(de.Name(), filepath.Separator) {
+			t.Errorf("cache wrote a path-like file name %q", de.Name())
+		}
+	}
+
+	want := filepath.Join(dir, hashKey(maliciousKey))
+	if _, err := os.Stat(want); err != nil {
+		t.Errorf("expected cached file at hashed path %s: %v", want, err)
+	}
+
+	if _, _, ok := c.Get(maliciousKey); !ok {
+		t.Error("Get: expected hit for the same malicious key used in Set")
+	}
+}
+
+// TestDiskCacheRebuildIndexOrdersByModTime guards against rebuildIndex
+// registering entries in os.ReadDir order (alphabetical by hashed name)
+// instead of actual recency: a restart must preserve which entry is truly
+// least-recently-used, or eviction order after a restart has no
+// relationship to which entries were actually touched last.
+func TestDiskCacheRebuildIndexOrdersByModTime(t *testing.T) {
+	dir := t.TempDir()
+	c, err := NewDiskCache(dir, defaultCacheMaxSize, 0)
+	if err != nil {
+		t.Fatalf("NewDiskCache: %v", err)
+	}
+
+	if err := c.Set("old", []byte("1"), Meta{}); err != nil {
+		t.Fatalf("Set(old): %v", err)
+	}
+	time.Sleep(20 * time.Millisecond) // ensure a distinct, later mtime for "new"
+	if err := c.Set("new", []byte("1"), Meta{}); err != nil {
+		t.Fatalf("Set(new): %v", err)
+	}
+
+	// Rebuild a fresh DiskCache from the same directory, simulating a
+	// restart. Cap it so a third 1-byte entry forces exactly one eviction.
+	rebuilt, err := NewDiskCache(dir, 2, 0)
+	if err != nil {
+		t.Fatalf("NewDiskCache (rebuilt): %v", err)
+	}
+	if err := rebuilt.Set("third", []byte("1"), Meta{}); err != nil {
+		t.Fatalf("Set(third): %v", err)
+	}
+
+	if _, _, ok := rebuilt.Get("old"); ok {
+		t.Error(`Get("old"): expected eviction as the least-recently-modified entry after rebuild`)
+	}
+	if rc, _, ok := rebuilt.Get("new"); !ok {
+		t.Error(`Get("new"): expected to survive eviction as the most-recently-modified entry after rebuild`)
+	} else {
+		rc.Close()
+	}
+}
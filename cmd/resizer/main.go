@@ -0,0 +1,83 @@
+// Command resizer is an out-of-process image resizer. It reads an image on
+// stdin, resizes it to the given dimensions, and writes the re-encoded
+// result to stdout. The NakamaCDN server spawns one of these per untrusted
+// resize so a decoder crash or OOM on an adversarial image takes down this
+// short-lived process instead of the server itself.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"os"
+
+	"github.com/disintegration/imaging"
+
+	"github.com/Edgajuman/NakamaCDN/internal/imgformat"
+	"github.com/Edgajuman/NakamaCDN/internal/imgresize"
+)
+
+func main() {
+	width := flag.Int("width", 0, "target width (0 preserves aspect ratio against height)")
+	height := flag.Int("height", 0, "target height (0 preserves aspect ratio against width)")
+	format := flag.String("format", "jpeg", "output format to encode the result as")
+	maxOutputPixels := flag.Int64("max-output-pixels", 64_000_000, "reject resizes that would produce more than this many pixels")
+	maxSourcePixels := flag.Int64("max-source-pixels", 500_000_000, "reject sources whose decoded dimensions exceed this many pixels, checked before the full decode")
+	flag.Parse()
+
+	if err := run(os.Stdin, os.Stdout, *width, *height, *format, *maxOutputPixels, *maxSourcePixels); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// run reads a source image from r, resizes and re-encodes it per the given
+// parameters, and writes the result to w. Taking r/w as parameters (rather
+// than reading os.Stdin/os.Stdout directly) keeps the resource-limit logic
+// unit-testable without forking the binary.
+func run(r io.Reader, w io.Writer, width, height int, format string, maxOutputPixels, maxSourcePixels int64) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("reading source image: %w", err)
+	}
+
+	// Check the source's decoded dimensions before the full decode: a
+	// small, highly-compressible image (a decompression bomb) can still
+	// force a multi-gigabyte allocation during image.Decode, well before
+	// the resized-output check below ever runs.
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("reading image config: %w", err)
+	}
+	if sourcePixels := int64(cfg.Width) * int64(cfg.Height); sourcePixels > maxSourcePixels {
+		return fmt.Errorf("source image has %d pixels, exceeding the %d limit", sourcePixels, maxSourcePixels)
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("decoding image: %w", err)
+	}
+
+	// Compute the output dimensions imaging.Resize would produce (including
+	// its 0-preserves-aspect-ratio behavior) and reject before calling it:
+	// the allocation maxOutputPixels exists to prevent happens inside
+	// Resize itself, so checking its result is too late to bound anything.
+	dstW, dstH := imgresize.Dimensions(cfg.Width, cfg.Height, width, height)
+	if outputPixels := int64(dstW) * int64(dstH); outputPixels > maxOutputPixels {
+		return fmt.Errorf("resized image would have %d pixels, exceeding the %d limit", outputPixels, maxOutputPixels)
+	}
+
+	resized := imaging.Resize(src, width, height, imaging.Lanczos)
+
+	bw := bufio.NewWriter(w)
+	if _, err := imgformat.Encode(bw, resized, format); err != nil {
+		return fmt.Errorf("encoding image: %w", err)
+	}
+	return bw.Flush()
+}
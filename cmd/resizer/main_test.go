@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testImage returns a w*h PNG-encoded solid-color image.
+func testImage(t *testing.T, w, h int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{R: 200, G: 100, B: 50, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("encoding test image: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestRun(t *testing.T) {
+	src := testImage(t, 20, 20)
+
+	tests := []struct {
+		name            string
+		src             []byte
+		width, height   int
+		format          string
+		maxOutputPixels int64
+		maxSourcePixels int64
+		wantErr         string
+	}{
+		{
+			name:            "ok",
+			src:             src,
+			width:           10,
+			height:          10,
+			format:          "png",
+			maxOutputPixels: 64_000_000,
+			maxSourcePixels: 500_000_000,
+		},
+		{
+			name:            "source exceeds max source pixels",
+			src:             src,
+			width:           10,
+			height:          10,
+			format:          "png",
+			maxOutputPixels: 64_000_000,
+			maxSourcePixels: 100, // src is 20x20=400 pixels
+			wantErr:         "source image has 400 pixels",
+		},
+		{
+			name:            "resized output exceeds max output pixels",
+			src:             src,
+			width:           10,
+			height:          10,
+			format:          "png",
+			maxOutputPixels: 50, // 10x10=100 pixels
+			maxSourcePixels: 500_000_000,
+			wantErr:         "resized image would have 100 pixels",
+		},
+		{
+			name:            "unsupported output format",
+			src:             src,
+			width:           10,
+			height:          10,
+			format:          "avif",
+			maxOutputPixels: 64_000_000,
+			maxSourcePixels: 500_000_000,
+			wantErr:         "unsupported format",
+		},
+		{
+			name:            "undecodable source",
+			src:             []byte("not an image"),
+			width:           10,
+			height:          10,
+			format:          "png",
+			maxOutputPixels: 64_000_000,
+			maxSourcePixels: 500_000_000,
+			wantErr:         "reading image config",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := run(bytes.NewReader(tt.src), &out, tt.width, tt.height, tt.format, tt.maxOutputPixels, tt.maxSourcePixels)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("run() returned unexpected error: %v", err)
+				}
+				if out.Len() == 0 {
+					t.Fatal("run() produced no output")
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("run() succeeded, want error containing %q", tt.wantErr)
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("run() error = %q, want it to contain %q", err.Error(), tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestRunRejectsHugeOutputBeforeResizing guards against the output-pixel
+// check running after imaging.Resize has already allocated the oversized
+// result: a request for a huge width/height against a tiny source must be
+// rejected quickly, not after minutes spent resizing.
+func TestRunRejectsHugeOutputBeforeResizing(t *testing.T) {
+	src := testImage(t, 10, 10)
+
+	done := make(chan error, 1)
+	go func() {
+		var out bytes.Buffer
+		done <- run(bytes.NewReader(src), &out, 20000, 20000, "png", 64_000_000, 500_000_000)
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("run() succeeded, want an error for a 20000x20000 request exceeding maxOutputPixels")
+		}
+		if !strings.Contains(err.Error(), "exceeding the 64000000 limit") {
+			t.Fatalf("run() error = %q, want it to mention the output pixel limit", err.Error())
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("run() took too long to reject an oversized request — the output-pixel check must run before imaging.Resize, not after")
+	}
+}
@@ -0,0 +1,82 @@
+// Package imgformat maps the format names accepted on the wire (the
+// ?format= query param, an Accept header, or a resizer subprocess flag) to
+// a Content-Type and an encoder. It's shared by the main server and the
+// cmd/resizer subprocess so the two agree on what a format name means.
+package imgformat
+
+import (
+	"fmt"
+	"image"
+	"io"
+	"strings"
+
+	"github.com/chai2010/webp"
+	"github.com/disintegration/imaging"
+)
+
+// ContentType returns the canonical Content-Type for a format name. An
+// empty name defaults to JPEG.
+//
+// avif is deliberately not accepted here: encoding it needs libaom, which
+// isn't vendored as C source like our webp encoder, so it isn't available
+// in every build. Add it back once Encode can actually produce it.
+func ContentType(name string) (string, error) {
+	switch strings.ToLower(name) {
+	case "", "jpeg", "jpg":
+		return "image/jpeg", nil
+	case "png":
+		return "image/png", nil
+	case "gif":
+		return "image/gif", nil
+	case "tiff":
+		return "image/tiff", nil
+	case "bmp":
+		return "image/bmp", nil
+	case "webp":
+		return "image/webp", nil
+	default:
+		return "", fmt.Errorf("unsupported format %q", name)
+	}
+}
+
+// Encode writes img to w in the given format and returns its Content-Type.
+// An empty name defaults to JPEG.
+func Encode(w io.Writer, img image.Image, name string) (string, error) {
+	contentType, err := ContentType(name)
+	if err != nil {
+		return "", err
+	}
+
+	switch strings.ToLower(name) {
+	case "webp":
+		if err := webp.Encode(w, img, &webp.Options{Quality: 80}); err != nil {
+			return "", fmt.Errorf("encoding webp: %w", err)
+		}
+	default:
+		format, err := imagingFormat(name)
+		if err != nil {
+			return "", err
+		}
+		if err := imaging.Encode(w, img, format); err != nil {
+			return "", fmt.Errorf("encoding %s: %w", name, err)
+		}
+	}
+	return contentType, nil
+}
+
+func imagingFormat(name string) (imaging.Format, error) {
+	switch strings.ToLower(name) {
+	case "", "jpeg", "jpg":
+		return imaging.JPEG, nil
+	case "png":
+		return imaging.PNG, nil
+	case "gif":
+		return imaging.GIF, nil
+	case "tiff":
+		return imaging.TIFF, nil
+	case "bmp":
+		return imaging.BMP, nil
+	default:
+		return 0, fmt.Errorf("unsupported format %q", name)
+	}
+}
@@ -0,0 +1,25 @@
+// Package imgresize computes the output dimensions a resize would produce
+// without performing it, so a resize's cost can be bounded before the
+// allocation it would cause. It's shared by the main server's in-process
+// resize path and the cmd/resizer subprocess so both agree on what a given
+// width/height request actually costs.
+package imgresize
+
+import "math"
+
+// Dimensions mirrors imaging.Resize's own "if width or height is 0, preserve
+// aspect ratio, minimum 1px" rule for a srcW x srcH source being resized
+// toward width/height, without allocating the resized image itself.
+func Dimensions(srcW, srcH, width, height int) (int, int) {
+	dstW, dstH := width, height
+	if dstW == 0 && dstH == 0 {
+		return srcW, srcH
+	}
+	if dstW == 0 {
+		dstW = int(math.Max(1.0, math.Floor(float64(dstH)*float64(srcW)/float64(srcH)+0.5)))
+	}
+	if dstH == 0 {
+		dstH = int(math.Max(1.0, math.Floor(float64(dstW)*float64(srcH)/float64(srcW)+0.5)))
+	}
+	return dstW, dstH
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"image"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/disintegration/imaging"
+
+	"github.com/Edgajuman/NakamaCDN/internal/imgformat"
+	"github.com/Edgajuman/NakamaCDN/internal/imgresize"
+)
+
+// LoadOptions customizes how AssetLoader.Load retrieves and transforms an asset.
+type LoadOptions struct {
+	// Width and Height, if non-zero, request the asset be resized
+	// (ImageLoader).
+	Width, Height int
+	// Format, if set, requests the asset be transcoded to this image format
+	// before being returned (ImageLoader).
+	Format string
+	// ArchivePath, if set, requests a single member be extracted from a zip
+	// or tar archive rather than returning the archive itself (ArchiveLoader).
+	ArchivePath string
+	// Gzip requests the response body be gzip-compressed (GzipLoader).
+	Gzip bool
+}
+
+// Meta describes the asset a Load call returned.
+type Meta struct {
+	ContentType     string
+	ContentEncoding string
+	Size            int64 // -1 if unknown
+	ModTime         time.Time
+}
+
+// AssetLoader retrieves a named asset, optionally applying a transformation.
+// Loaders are composed as middleware: each wraps an inner AssetLoader and
+// transforms the stream/metadata it returns, so new transformations can be
+// added to the pipeline without touching HTTP handlers.
+type AssetLoader interface {
+	Load(ctx context.Context, name string, opts LoadOptions) (io.ReadCloser, Meta, error)
+}
+
+// StoreLoader is the base of every chain: it reads the raw asset straight
+// from a Storage backend and ignores any transformation options.
+type StoreLoader struct {
+	store Storage
+}
+
+// NewStoreLoader returns an AssetLoader backed directly by store.
+func NewStoreLoader(store Storage) *StoreLoader {
+	return &StoreLoader{store: store}
+}
+
+func (l *StoreLoader) Load(ctx context.Context, name string, opts LoadOptions) (io.ReadCloser, Meta, error) {
+	info, err := l.store.Stat(ctx, name)
+	if err != nil {
+		return nil, Meta{}, err
+	}
+	rc, err := l.store.Get(ctx, name)
+	if err != nil {
+		return nil, Meta{}, err
+	}
+	return rc, Meta{ContentType: "application/octet-stream", Size: info.Size, ModTime: info.ModTime}, nil
+}
+
+// ArchiveLoader extracts a single member from a zip or tar archive when
+// opts.ArchivePath is set; otherwise it passes the asset through unchanged.
+type ArchiveLoader struct {
+	next AssetLoader
+}
+
+// NewArchiveLoader wraps next with archive-member extraction.
+func NewArchiveLoader(next AssetLoader) *ArchiveLoader {
+	return &ArchiveLoader{next: next}
+}
+
+func (l *ArchiveLoader) Load(ctx context.Context, name string, opts LoadOptions) (io.ReadCloser, Meta, error) {
+	rc, meta, err := l.next.Load(ctx, name, opts)
+	if err != nil || opts.ArchivePath == "" {
+		return rc, meta, err
+	}
+	defer rc.Close()
+
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, Meta{}, fmt.Errorf("reading archive %s: %w", name, err)
+	}
+
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		return extractFromZip(data, opts.ArchivePath, meta)
+	case strings.HasSuffix(name, ".tar"), strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return extractFromTar(data, name, opts.ArchivePath, meta)
+	default:
+		return nil, Meta{}, fmt.Errorf("%s is not a recognized archive format", name)
+	}
+}
+
+func extractFromZip(data []byte, member string, meta Meta) (io.ReadCloser, Meta, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, Meta{}, fmt.Errorf("opening zip: %w", err)
+	}
+	for _, f := range zr.File {
+		if f.Name != member {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, Meta{}, err
+		}
+		meta.Size = int64(f.UncompressedSize64)
+		meta.ModTime = f.Modified
+		return rc, meta, nil
+	}
+	return nil, Meta{}, fmt.Errorf("%s not found in archive", member)
+}
+
+func extractFromTar(data []byte, archiveName, member string, meta Meta) (io.ReadCloser, Meta, error) {
+	var r io.Reader = bytes.NewReader(data)
+	if strings.HasSuffix(archiveName, ".tar.gz") || strings.HasSuffix(archiveName, ".tgz") {
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, Meta{}, fmt.Errorf("opening gzip: %w", err)
+		}
+		r = gr
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, Meta{}, fmt.Errorf("reading tar: %w", err)
+		}
+		if hdr.Name != member {
+			continue
+		}
+		buf, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, Meta{}, err
+		}
+		meta.Size = hdr.Size
+		meta.ModTime = hdr.ModTime
+		return io.NopCloser(bytes.NewReader(buf)), meta, nil
+	}
+	return nil, Meta{}, fmt.Errorf("%s not found in archive", member)
+}
+
+// ImageLoader resizes and/or transcodes image assets when opts.Width,
+// opts.Height, or opts.Format is set; otherwise it passes the asset through
+// unchanged. If resizer is set, the decode/resize/encode all happen in that
+// out-of-process subprocess instead of in this goroutine, so a crash or OOM
+// on an adversarial image can't take down the server — this applies even to
+// a format-only request with no resize, since decoding untrusted bytes is
+// the dangerous part, not the resize math. If resizer is nil, the same
+// maxSourcePixels/maxOutputPixels bounds the subprocess would enforce are
+// checked in-process instead, since RESIZER_BINARY is opt-in and this is a
+// public, unauthenticated endpoint either way.
+type ImageLoader struct {
+	next            AssetLoader
+	resizer         *ExternalResizer
+	maxSourcePixels int64
+	maxOutputPixels int64
+}
+
+// NewImageLoader wraps next with image resize/format conversion. resizer
+// may be nil, in which case resizing happens in-process, bounded by
+// maxSourcePixels/maxOutputPixels the same way the external resizer
+// subprocess would bound it.
+func NewImageLoader(next AssetLoader, resizer *ExternalResizer, maxSourcePixels, maxOutputPixels int64) *ImageLoader {
+	return &ImageLoader{next: next, resizer: resizer, maxSourcePixels: maxSourcePixels, maxOutputPixels: maxOutputPixels}
+}
+
+func (l *ImageLoader) Load(ctx context.Context, name string, opts LoadOptions) (io.ReadCloser, Meta, error) {
+	rc, meta, err := l.next.Load(ctx, name, opts)
+	if err != nil {
+		return rc, meta, err
+	}
+	if opts.Width == 0 && opts.Height == 0 && opts.Format == "" {
+		return rc, meta, nil
+	}
+	defer rc.Close()
+
+	var encoded []byte
+	var contentType string
+	if l.resizer != nil {
+		encoded, err = l.resizer.Resize(ctx, rc, opts.Width, opts.Height, opts.Format)
+		if err != nil {
+			return nil, Meta{}, err
+		}
+		contentType, err = imgformat.ContentType(opts.Format)
+		if err != nil {
+			return nil, Meta{}, err
+		}
+	} else {
+		data, err := io.ReadAll(rc)
+		if err != nil {
+			return nil, Meta{}, fmt.Errorf("reading image %s: %w", name, err)
+		}
+
+		// Check the source's decoded dimensions before the full decode (a
+		// small, highly-compressible image can still force a
+		// multi-gigabyte allocation during imaging.Decode), then compute
+		// the output imaging.Resize would produce and reject before
+		// calling it — checking its result would be too late to bound the
+		// allocation it already made.
+		cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			return nil, Meta{}, fmt.Errorf("reading image config %s: %w", name, err)
+		}
+		if sourcePixels := int64(cfg.Width) * int64(cfg.Height); l.maxSourcePixels > 0 && sourcePixels > l.maxSourcePixels {
+			return nil, Meta{}, fmt.Errorf("source image %s has %d pixels, exceeding the %d limit", name, sourcePixels, l.maxSourcePixels)
+		}
+		dstW, dstH := imgresize.Dimensions(cfg.Width, cfg.Height, opts.Width, opts.Height)
+		if outputPixels := int64(dstW) * int64(dstH); l.maxOutputPixels > 0 && outputPixels > l.maxOutputPixels {
+			return nil, Meta{}, fmt.Errorf("resized image %s would have %d pixels, exceeding the %d limit", name, outputPixels, l.maxOutputPixels)
+		}
+
+		img, err := imaging.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, Meta{}, fmt.Errorf("decoding image %s: %w", name, err)
+		}
+		if opts.Width != 0 || opts.Height != 0 {
+			img = imaging.Resize(img, opts.Width, opts.Height, imaging.Lanczos)
+		}
+		var buf bytes.Buffer
+		contentType, err = imgformat.Encode(&buf, img, opts.Format)
+		if err != nil {
+			return nil, Meta{}, fmt.Errorf("encoding image %s: %w", name, err)
+		}
+		encoded = buf.Bytes()
+	}
+
+	meta.ContentType = contentType
+	meta.Size = int64(len(encoded))
+	return io.NopCloser(bytes.NewReader(encoded)), meta, nil
+}
+
+// GzipLoader transparently gzip-compresses the response body when
+// opts.Gzip is set; otherwise it passes the asset through unchanged.
+type GzipLoader struct {
+	next AssetLoader
+}
+
+// NewGzipLoader wraps next with transparent gzip compression.
+func NewGzipLoader(next AssetLoader) *GzipLoader {
+	return &GzipLoader{next: next}
+}
+
+func (l *GzipLoader) Load(ctx context.Context, name string, opts LoadOptions) (io.ReadCloser, Meta, error) {
+	rc, meta, err := l.next.Load(ctx, name, opts)
+	if err != nil || !opts.Gzip {
+		return rc, meta, err
+	}
+	defer rc.Close()
+
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	if _, err := io.Copy(gw, rc); err != nil {
+		return nil, Meta{}, fmt.Errorf("compressing %s: %w", name, err)
+	}
+	if err := gw.Close(); err != nil {
+		return nil, Meta{}, fmt.Errorf("compressing %s: %w", name, err)
+	}
+
+	meta.ContentEncoding = "gzip"
+	meta.Size = int64(buf.Len())
+	return io.NopCloser(&buf), meta, nil
+}
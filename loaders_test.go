@@ -0,0 +1,307 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"image"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeLoader is a fixed-content AssetLoader stand-in for the next loader in
+// a chain, so each wrapper can be tested in isolation from the real
+// storage/archive/image machinery below it.
+type fakeLoader struct {
+	data []byte
+	meta Meta
+}
+
+func (f *fakeLoader) Load(ctx context.Context, name string, opts LoadOptions) (io.ReadCloser, Meta, error) {
+	return io.NopCloser(bytes.NewReader(f.data)), f.meta, nil
+}
+
+func buildZip(t *testing.T, files map[string][]byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatalf("zip.Create(%s): %v", name, err)
+		}
+		if _, err := w.Write(content); err != nil {
+			t.Fatalf("writing zip member %s: %v", name, err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("closing zip writer: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func buildTar(t *testing.T, files map[string][]byte, gzipped bool) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	var tw *tar.Writer
+	var gw *gzip.Writer
+	if gzipped {
+		gw = gzip.NewWriter(&buf)
+		tw = tar.NewWriter(gw)
+	} else {
+		tw = tar.NewWriter(&buf)
+	}
+	for name, content := range files {
+		hdr := &tar.Header{Name: name, Size: int64(len(content)), Mode: 0644}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatalf("tar.WriteHeader(%s): %v", name, err)
+		}
+		if _, err := tw.Write(content); err != nil {
+			t.Fatalf("writing tar member %s: %v", name, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("closing tar writer: %v", err)
+	}
+	if gzipped {
+		if err := gw.Close(); err != nil {
+			t.Fatalf("closing gzip writer: %v", err)
+		}
+	}
+	return buf.Bytes()
+}
+
+func TestArchiveLoaderZipMember(t *testing.T) {
+	zipData := buildZip(t, map[string][]byte{"a.txt": []byte("one"), "b.txt": []byte("two")})
+	l := NewArchiveLoader(&fakeLoader{data: zipData, meta: Meta{ContentType: "application/octet-stream"}})
+
+	rc, _, err := l.Load(context.Background(), "archive.zip", LoadOptions{ArchivePath: "b.txt"})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	defer rc.Close()
+	got, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("reading extracted member: %v", err)
+	}
+	if string(got) != "two" {
+		t.Errorf("extracted member = %q, want %q", got, "two")
+	}
+}
+
+func TestArchiveLoaderZipMemberNotFound(t *testing.T) {
+	zipData := buildZip(t, map[string][]byte{"a.txt": []byte("one")})
+	l := NewArchiveLoader(&fakeLoader{data: zipData})
+
+	if _, _, err := l.Load(context.Background(), "archive.zip", LoadOptions{ArchivePath: "missing.txt"}); err == nil {
+		t.Error("Load: expected an error for a missing archive member")
+	}
+}
+
+func TestArchiveLoaderTarMember(t *testing.T) {
+	tarData := buildTar(t, map[string][]byte{"a.txt": []byte("hello tar")}, false)
+	l := NewArchiveLoader(&fakeLoader{data: tarData})
+
+	rc, _, err := l.Load(context.Background(), "archive.tar", LoadOptions{ArchivePath: "a.txt"})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	defer rc.Close()
+	got, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("reading extracted member: %v", err)
+	}
+	if string(got) != "hello tar" {
+		t.Errorf("extracted member = %q, want %q", got, "hello tar")
+	}
+}
+
+func TestArchiveLoaderTarGzMember(t *testing.T) {
+	tarGzData := buildTar(t, map[string][]byte{"a.txt": []byte("hello tar.gz")}, true)
+	l := NewArchiveLoader(&fakeLoader{data: tarGzData})
+
+	rc, _, err := l.Load(context.Background(), "archive.tar.gz", LoadOptions{ArchivePath: "a.txt"})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	defer rc.Close()
+	got, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("reading extracted member: %v", err)
+	}
+	if string(got) != "hello tar.gz" {
+		t.Errorf("extracted member = %q, want %q", got, "hello tar.gz")
+	}
+}
+
+func TestArchiveLoaderUnrecognizedFormat(t *testing.T) {
+	l := NewArchiveLoader(&fakeLoader{data: []byte("not an archive")})
+	if _, _, err := l.Load(context.Background(), "file.rar", LoadOptions{ArchivePath: "a.txt"}); err == nil {
+		t.Error("Load: expected an error for an unrecognized archive format")
+	}
+}
+
+func TestArchiveLoaderPassthroughWithoutArchivePath(t *testing.T) {
+	next := &fakeLoader{data: []byte("raw bytes"), meta: Meta{ContentType: "application/octet-stream"}}
+	l := NewArchiveLoader(next)
+
+	rc, meta, err := l.Load(context.Background(), "file.zip", LoadOptions{})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	defer rc.Close()
+	got, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("reading passthrough body: %v", err)
+	}
+	if string(got) != "raw bytes" {
+		t.Errorf("passthrough body = %q, want %q", got, "raw bytes")
+	}
+	if meta != next.meta {
+		t.Errorf("passthrough meta = %+v, want %+v", meta, next.meta)
+	}
+}
+
+func TestGzipLoaderCompresses(t *testing.T) {
+	next := &fakeLoader{data: []byte("compress me"), meta: Meta{ContentType: "text/plain"}}
+	l := NewGzipLoader(next)
+
+	rc, meta, err := l.Load(context.Background(), "file.txt", LoadOptions{Gzip: true})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	defer rc.Close()
+	if meta.ContentEncoding != "gzip" {
+		t.Errorf("meta.ContentEncoding = %q, want %q", meta.ContentEncoding, "gzip")
+	}
+
+	gr, err := gzip.NewReader(rc)
+	if err != nil {
+		t.Fatalf("gzip.NewReader: %v", err)
+	}
+	got, err := io.ReadAll(gr)
+	if err != nil {
+		t.Fatalf("reading decompressed body: %v", err)
+	}
+	if string(got) != "compress me" {
+		t.Errorf("decompressed body = %q, want %q", got, "compress me")
+	}
+}
+
+func TestGzipLoaderPassthroughWithoutGzipOption(t *testing.T) {
+	next := &fakeLoader{data: []byte("plain"), meta: Meta{ContentType: "text/plain"}}
+	l := NewGzipLoader(next)
+
+	rc, meta, err := l.Load(context.Background(), "file.txt", LoadOptions{})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	defer rc.Close()
+	if meta.ContentEncoding != "" {
+		t.Errorf("meta.ContentEncoding = %q, want empty", meta.ContentEncoding)
+	}
+	got, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("reading passthrough body: %v", err)
+	}
+	if string(got) != "plain" {
+		t.Errorf("passthrough body = %q, want %q", got, "plain")
+	}
+}
+
+func TestImageLoaderResizeAndTranscode(t *testing.T) {
+	next := &fakeLoader{data: testPNG(t, 40, 20), meta: Meta{ContentType: "application/octet-stream"}}
+	l := NewImageLoader(next, nil, defaultMaxSourcePixels, defaultMaxOutputPixels)
+
+	rc, meta, err := l.Load(context.Background(), "source.png", LoadOptions{Width: 10, Height: 8, Format: "jpeg"})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("reading resized body: %v", err)
+	}
+
+	if meta.ContentType != "image/jpeg" {
+		t.Errorf("meta.ContentType = %q, want %q", meta.ContentType, "image/jpeg")
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("decoding resized image: %v", err)
+	}
+	if cfg.Width != 10 || cfg.Height != 8 {
+		t.Errorf("resized to %dx%d, want 10x8", cfg.Width, cfg.Height)
+	}
+}
+
+func TestImageLoaderPassthroughWithoutTransformOptions(t *testing.T) {
+	raw := []byte("not decoded, passed straight through")
+	next := &fakeLoader{data: raw, meta: Meta{ContentType: "application/octet-stream"}}
+	l := NewImageLoader(next, nil, defaultMaxSourcePixels, defaultMaxOutputPixels)
+
+	rc, meta, err := l.Load(context.Background(), "source.bin", LoadOptions{})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	defer rc.Close()
+	got, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("reading passthrough body: %v", err)
+	}
+	if string(got) != string(raw) {
+		t.Errorf("passthrough body = %q, want %q", got, raw)
+	}
+	if meta != next.meta {
+		t.Errorf("passthrough meta = %+v, want %+v", meta, next.meta)
+	}
+}
+
+// TestImageLoaderEnforcesOutputPixelLimitInProcess guards against the
+// in-process resize path (used whenever RESIZER_BINARY is unset, the
+// default) having no bound on resize cost: a public, unauthenticated
+// ?width=&height= on a public endpoint must be rejected against
+// maxOutputPixels the same way the external resizer subprocess would reject
+// it, and rejected before imaging.Resize allocates the oversized result.
+func TestImageLoaderEnforcesOutputPixelLimitInProcess(t *testing.T) {
+	next := &fakeLoader{data: testPNG(t, 10, 10), meta: Meta{ContentType: "application/octet-stream"}}
+	l := NewImageLoader(next, nil, defaultMaxSourcePixels, 50) // 10x10 output = 100 pixels > 50
+
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := l.Load(context.Background(), "source.png", LoadOptions{Width: 20000, Height: 20000})
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("Load: expected an error for a 20000x20000 request exceeding maxOutputPixels")
+		}
+		if !strings.Contains(err.Error(), "exceeding the 50 limit") {
+			t.Fatalf("Load error = %q, want it to mention the output pixel limit", err.Error())
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Load took too long to reject an oversized request — the output-pixel check must run before imaging.Resize, not after")
+	}
+}
+
+// TestImageLoaderEnforcesSourcePixelLimitInProcess guards the same in-process
+// path against an oversized source image, independent of what width/height
+// is requested.
+func TestImageLoaderEnforcesSourcePixelLimitInProcess(t *testing.T) {
+	next := &fakeLoader{data: testPNG(t, 20, 20), meta: Meta{ContentType: "application/octet-stream"}}
+	l := NewImageLoader(next, nil, 100, defaultMaxOutputPixels) // src is 20x20=400 pixels > 100
+
+	_, _, err := l.Load(context.Background(), "source.png", LoadOptions{Width: 10, Height: 10})
+	if err == nil {
+		t.Fatal("Load: expected an error for a source image exceeding maxSourcePixels")
+	}
+	if !strings.Contains(err.Error(), "exceeding the 100 limit") {
+		t.Fatalf("Load error = %q, want it to mention the source pixel limit", err.Error())
+	}
+}
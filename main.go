@@ -3,30 +3,77 @@
 package main
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
-	"github.com/gin-gonic/gin"
 	"github.com/disintegration/imaging"
-	"github.com/patrickmn/go-cache"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"golang.org/x/sync/singleflight"
+
+	"github.com/Edgajuman/NakamaCDN/internal/imgformat"
 )
 
 // Constants define the application's configuration parameters
 const (
-	uploadDir = "./uploads" // Directory to store uploaded images
-	cacheDir  = "./cache"   // Directory to store resized/processed images
+	uploadDir = "./uploads" // Directory to store uploaded images (local backend only)
+	cacheDir  = "./cache"   // Directory to store resized/processed images (local backend only)
 	apiToken  = "YOUR CUSTOM API TOKEN"        // Authentication token for protected routes
 )
 
 // Global variables
 var (
-	imageCache *cache.Cache // In-memory cache for optimizing image serving performance
+	// uploadStorage holds original uploads, pluggable via STORAGE_BACKEND.
+	uploadStorage Storage
+
+	// diskCache holds derived renditions (resizes). It's always a bounded
+	// on-disk cache, independent of the upload storage backend, so the CDN
+	// node always has a local disk cache to serve from regardless of where
+	// originals live.
+	diskCache *DiskCache
+
+	// assetLoader is the chain handlers dispatch through for every read:
+	// StoreLoader fetches the raw bytes, then ArchiveLoader, ImageLoader and
+	// GzipLoader each apply their transformation only if the caller asked
+	// for it via LoadOptions. New transformations are added by wrapping this
+	// chain, not by touching the handlers below.
+	assetLoader AssetLoader
+
+	// resizeGroup ensures only one goroutine performs the resize for a
+	// given cacheKey at a time; concurrent requests for the same resize
+	// wait for it and share the result instead of racing to decode/resize/
+	// write the same cache file.
+	resizeGroup singleflight.Group
 )
 
+// errAssetNotFound distinguishes a missing source asset (404) from any
+// other resize failure (500) when unwrapping a resizeGroup result.
+var errAssetNotFound = errors.New("asset not found")
+
+// resizeResult is what a resizeGroup.Do call produces: the resized bytes
+// plus the metadata (content type/encoding) they were produced with.
+type resizeResult struct {
+	data []byte
+	meta Meta
+}
+
+// contentHashPattern matches the hex-encoded SHA-256 hash handleImageUpload
+// names uploads after, so serveImage can tell a content-addressed filename
+// apart from one created before this convention existed.
+var contentHashPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
 // authMiddleware implements API token validation for protected routes.
 // It checks for the presence and validity of the X-API-Token header.
 // Returns 401 Unauthorized if the token is missing or invalid.
@@ -49,18 +96,53 @@ func authMiddleware() gin.HandlerFunc {
 	}
 }
 
-// init initializes the application by setting up required directories
-// and configuring the in-memory cache with specified expiration times.
+// init initializes the application by setting up the storage backend, the
+// asset loader chain, and the disk cache.
 func init() {
-	// Create upload and cache directories if they do not exist
-	for _, dir := range []string{uploadDir, cacheDir} {
-		if err := os.MkdirAll(dir, 0755); err != nil {
-			log.Fatalf("Error creating directory %s: %v", dir, err)
+	var err error
+	uploadStorage, err = newStorageFromEnv(uploadDir, "uploads/")
+	if err != nil {
+		log.Fatalf("Error initializing upload storage: %v", err)
+	}
+
+	maxSize := int64(defaultCacheMaxSize)
+	if v := os.Getenv("CACHE_MAX_SIZE_BYTES"); v != "" {
+		parsed, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			log.Fatalf("Invalid CACHE_MAX_SIZE_BYTES %q: %v", v, err)
 		}
+		maxSize = parsed
+	}
+	maxAge := defaultCacheMaxAge
+	if v := os.Getenv("CACHE_MAX_AGE"); v != "" {
+		parsed, err := time.ParseDuration(v)
+		if err != nil {
+			log.Fatalf("Invalid CACHE_MAX_AGE %q: %v", v, err)
+		}
+		maxAge = parsed
+	}
+	diskCache, err = NewDiskCache(cacheDir, maxSize, maxAge)
+	if err != nil {
+		log.Fatalf("Error initializing disk cache: %v", err)
 	}
 
-	// Initialize cache with a default expiration of 5 minutes and cleanup interval of 10 minutes
-	imageCache = cache.New(5*time.Minute, 10*time.Minute)
+	resizer, err := newExternalResizerFromEnv()
+	if err != nil {
+		log.Fatalf("Error initializing external resizer: %v", err)
+	}
+	if resizer != nil {
+		log.Printf("Resizing untrusted uploads via external resizer subprocess %s", os.Getenv("RESIZER_BINARY"))
+	}
+
+	// These bound the in-process resize path the same way they bound the
+	// external resizer subprocess, since RESIZER_BINARY is opt-in and
+	// /api/resize is public and unauthenticated either way.
+	maxOutputPixels, maxSourcePixels, err := resizeLimitsFromEnv()
+	if err != nil {
+		log.Fatalf("Error loading resize limits: %v", err)
+	}
+
+	assetLoader = NewGzipLoader(NewImageLoader(NewArchiveLoader(NewStoreLoader(uploadStorage)), resizer, maxSourcePixels, maxOutputPixels))
 }
 
 // main is the entry point of the application.
@@ -76,12 +158,18 @@ func main() {
 	authGroup.Use(authMiddleware())
 	{
 		authGroup.POST("/upload", handleImageUpload)
+		authGroup.GET("/cache", handleCacheStats)
+		authGroup.DELETE("/cache", handleCachePurge)
 	}
 
 	// Public routes for serving and resizing images (no authentication required)
 	r.GET("/api/image/:filename", serveImage)
 	r.GET("/api/resize/:filename", resizeImage)
 
+	// Prometheus metrics, including the external resizer's resize_duration_seconds,
+	// resize_errors_total and resize_inflight
+	r.GET("/metrics", gin.WrapH(promhttp.Handler()))
+
 	// Start the server
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -94,10 +182,12 @@ func main() {
 // handleImageUpload processes incoming image upload requests.
 // It:
 // - Validates the uploaded file
-// - Generates a unique filename
-// - Saves the file to the upload directory
+// - Strips EXIF metadata from JPEGs (privacy, smaller files)
+// - Names the file after the SHA-256 of its (post-strip) bytes, so
+//   identical uploads dedupe onto the same object instead of piling up
+// - Saves the file to the configured storage backend
 // - Returns the public URL for accessing the image
-// Returns 400 Bad Request if no image is provided
+// Returns 400 Bad Request if no image is provided or it can't be decoded
 // Returns 500 Internal Server Error if the save operation fails
 func handleImageUpload(c *gin.Context) {
 	file, err := c.FormFile("image")
@@ -106,96 +196,328 @@ func handleImageUpload(c *gin.Context) {
 		return
 	}
 
-	// Generate a unique filename
-	filename := fmt.Sprintf("%d_%s", time.Now().UnixNano(), file.Filename)
-	filepath := filepath.Join(uploadDir, filename)
+	src, err := file.Open()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error reading image"})
+		return
+	}
+	data, err := io.ReadAll(src)
+	src.Close()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error reading image"})
+		return
+	}
+
+	ext := strings.ToLower(filepath.Ext(file.Filename))
+	if ext == ".jpg" || ext == ".jpeg" {
+		stripped, err := stripEXIF(data)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JPEG image"})
+			return
+		}
+		data = stripped
+	}
+
+	sum := sha256.Sum256(data)
+	filename := hex.EncodeToString(sum[:]) + ext
+
+	ctx := c.Request.Context()
+	if _, err := uploadStorage.Stat(ctx, filename); err == nil {
+		// Identical content is already stored under this hash; skip the write.
+		c.JSON(http.StatusOK, gin.H{
+			"message":  "Image already exists",
+			"filename": uploadStorage.URL(filename),
+		})
+		return
+	}
 
-	if err := c.SaveUploadedFile(file, filepath); err != nil {
+	if err := uploadStorage.Put(ctx, filename, bytes.NewReader(data), int64(len(data))); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error saving image"})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"message":  "Image uploaded successfully",
-		"filename": "https://cdn.nakamastream.lat/api/image/" + filename,
+		"filename": uploadStorage.URL(filename),
 	})
 }
 
+// stripEXIF re-encodes a JPEG from scratch, which drops any EXIF metadata
+// the original carried (location, device, etc). AutoOrientation bakes the
+// EXIF orientation tag into the pixels first so stripping it doesn't
+// visually rotate the image.
+func stripEXIF(data []byte) ([]byte, error) {
+	img, err := imaging.Decode(bytes.NewReader(data), imaging.AutoOrientation(true))
+	if err != nil {
+		return nil, fmt.Errorf("decoding JPEG: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := imaging.Encode(&buf, img, imaging.JPEG); err != nil {
+		return nil, fmt.Errorf("encoding JPEG: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
 // serveImage handles requests for retrieving uploaded images.
-// It implements a caching mechanism to improve performance for frequently accessed images.
-// Features:
-// - Checks in-memory cache first
-// - Verifies file existence
-// - Caches file paths for subsequent requests
-// Returns 404 Not Found if the requested image doesn't exist
+// It dispatches through the asset loader chain, so a `path` query param
+// transparently serves a member out of a zip/tar upload, an `?format=` (or
+// Accept header) transcodes it, and a gzip-capable client transparently
+// gets a compressed body. Content-addressed uploads get a strong ETag so
+// browsers can revalidate with If-None-Match instead of re-downloading.
+// Returns 404 Not Found if the requested asset doesn't exist.
+// Returns 415 Unsupported Media Type for an unrecognized ?format=.
 func serveImage(c *gin.Context) {
 	filename := c.Param("filename")
-	filepath := filepath.Join(uploadDir, filename)
+	format := negotiateFormat(c)
+	if format != "" {
+		if _, err := imgformat.ContentType(format); err != nil {
+			c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	opts := LoadOptions{
+		Format:      format,
+		ArchivePath: c.Query("path"),
+		Gzip:        acceptsGzip(c),
+	}
+
+	// A plain fetch returns exactly what was uploaded, so its ETag can be
+	// read straight off the content-addressed filename without touching
+	// the body. Any transform below changes the bytes, so its ETag has to
+	// be computed from the transformed output instead.
+	if identityTransform(opts) {
+		if etag := etagFromFilename(filename); etag != "" {
+			if c.GetHeader("If-None-Match") == etag {
+				c.Status(http.StatusNotModified)
+				return
+			}
+			c.Header("ETag", etag)
+		}
 
-	// Check if the image is in cache
-	if cachedPath, found := imageCache.Get(filename); found {
-		c.File(cachedPath.(string))
+		rc, meta, err := assetLoader.Load(c.Request.Context(), filename, opts)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+			return
+		}
+		defer rc.Close()
+		if meta.ContentEncoding != "" {
+			c.Header("Content-Encoding", meta.ContentEncoding)
+		}
+		c.DataFromReader(http.StatusOK, meta.Size, meta.ContentType, rc, nil)
 		return
 	}
 
-	// Verify if the file exists
-	if _, err := os.Stat(filepath); os.IsNotExist(err) {
+	rc, meta, err := assetLoader.Load(c.Request.Context(), filename, opts)
+	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
 		return
 	}
+	defer rc.Close()
 
-	// Store the file path in cache
-	imageCache.Set(filename, filepath, cache.DefaultExpiration)
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error processing image"})
+		return
+	}
 
-	// Serve the image file
-	c.File(filepath)
+	etag := strongETag(data)
+	if c.GetHeader("If-None-Match") == etag {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	c.Header("ETag", etag)
+	if meta.ContentEncoding != "" {
+		c.Header("Content-Encoding", meta.ContentEncoding)
+	}
+	c.DataFromReader(http.StatusOK, int64(len(data)), meta.ContentType, bytes.NewReader(data), nil)
 }
 
 // resizeImage handles image resizing requests with caching.
-// Capabilities:
-// - Resizes images to specified dimensions (defaults to 300x300)
-// - Caches resized versions to avoid redundant processing
-// - Uses high-quality Lanczos resampling
+// It dispatches the source image through the asset loader chain (so
+// archive extraction and format conversion apply the same way they do for
+// serveImage) and caches the resulting bytes in diskCache, keyed on every
+// option that affects the bytes served (size, ?path=, format, gzip), with
+// the Meta they were produced with so a cache hit reports the right
+// Content-Type/Content-Encoding instead of a hardcoded one. Concurrent
+// requests for the same cacheKey single-flight through resizeGroup so only
+// one of them actually decodes/resizes/writes the cache entry. The cacheKey
+// deterministically hashes to a strong ETag, so a client that already has
+// this exact rendition can revalidate with If-None-Match instead of
+// re-downloading it.
 // Parameters:
 // - width: desired width (optional, default: 300)
 // - height: desired height (optional, default: 300)
+// - format: ?format= query param or Accept header, same as serveImage
 // Returns 404 Not Found if the source image doesn't exist
+// Returns 415 Unsupported Media Type for an unrecognized ?format=
+// Returns 429 Too Many Requests if the external resizer is saturated
 // Returns 500 Internal Server Error if resizing fails
 func resizeImage(c *gin.Context) {
 	filename := c.Param("filename")
 	width := c.DefaultQuery("width", "300")
 	height := c.DefaultQuery("height", "300")
+	ctx := c.Request.Context()
+
+	format := negotiateFormat(c)
+	if format != "" {
+		if _, err := imgformat.ContentType(format); err != nil {
+			c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	archivePath := c.Query("path")
+	gzip := acceptsGzip(c)
 
-	// Generate a cache key for the resized image
-	cacheKey := fmt.Sprintf("%s_%s_%s", filename, width, height)
+	// The cache key must fold in every option that changes the bytes
+	// served (not just width/height), or two requests that differ only in
+	// e.g. ?path=, ?format= or Accept-Encoding would collide on the same
+	// entry.
+	cacheKey := fmt.Sprintf("%s_%s_%s_%s_%s_%t", filename, width, height, archivePath, format, gzip)
+
+	// A given cacheKey always produces the same bytes, so its hashed disk
+	// name (the same hash diskCache stores it under) doubles as a strong
+	// ETag without needing to read the cached bytes back to hash them.
+	etag := `"` + hashKey(cacheKey) + `"`
+	if c.GetHeader("If-None-Match") == etag {
+		c.Status(http.StatusNotModified)
+		return
+	}
 
 	// Check if the resized image is already cached
-	if cachedPath, found := imageCache.Get(cacheKey); found {
-		c.File(cachedPath.(string))
+	if rc, meta, found := diskCache.Get(cacheKey); found {
+		defer rc.Close()
+		writeResizeResponse(c, etag, -1, meta, rc)
 		return
 	}
 
-	// Open the original image
-	srcPath := filepath.Join(uploadDir, filename)
-	src, err := imaging.Open(srcPath)
+	w, _ := strconv.Atoi(width)
+	h, _ := strconv.Atoi(height)
+	opts := LoadOptions{
+		Width:       w,
+		Height:      h,
+		Format:      format,
+		ArchivePath: archivePath,
+		Gzip:        gzip,
+	}
+
+	v, err, _ := resizeGroup.Do(cacheKey, func() (interface{}, error) {
+		// Re-check the cache: another goroutine may have populated it while
+		// we were waiting to become the leader for this key.
+		if rc, meta, found := diskCache.Get(cacheKey); found {
+			defer rc.Close()
+			data, err := io.ReadAll(rc)
+			if err != nil {
+				return nil, err
+			}
+			return resizeResult{data: data, meta: meta}, nil
+		}
+
+		rc, meta, err := assetLoader.Load(ctx, filename, opts)
+		if err != nil {
+			if errors.Is(err, errResizerSaturated) {
+				return nil, err
+			}
+			return nil, fmt.Errorf("%w: %v", errAssetNotFound, err)
+		}
+		defer rc.Close()
+
+		data, err := io.ReadAll(rc)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := diskCache.Set(cacheKey, data, meta); err != nil {
+			return nil, err
+		}
+
+		return resizeResult{data: data, meta: meta}, nil
+	})
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		switch {
+		case errors.Is(err, errResizerSaturated):
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Resizer is busy, try again shortly"})
+		case errors.Is(err, errAssetNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error processing image"})
+		}
 		return
 	}
 
-	// Resize the image
-	resized := imaging.Resize(src, 300, 300, imaging.Lanczos)
+	result := v.(resizeResult)
+	writeResizeResponse(c, etag, int64(len(result.data)), result.meta, bytes.NewReader(result.data))
+}
 
-	// Save the resized image in the cache directory
-	dstPath := filepath.Join(cacheDir, cacheKey)
-	if err := imaging.Save(resized, dstPath); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error processing image"})
-		return
+// writeResizeResponse sets etag and writes a resize result's body to c using
+// meta's Content-Type/Content-Encoding, falling back to
+// application/octet-stream for entries cached before Meta was persisted
+// alongside them.
+func writeResizeResponse(c *gin.Context, etag string, size int64, meta Meta, body io.Reader) {
+	c.Header("ETag", etag)
+	if meta.ContentEncoding != "" {
+		c.Header("Content-Encoding", meta.ContentEncoding)
+	}
+	contentType := meta.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
 	}
+	c.DataFromReader(http.StatusOK, size, contentType, body, nil)
+}
 
-	// Store the resized image path in cache
-	imageCache.Set(cacheKey, dstPath, cache.DefaultExpiration)
+// acceptsGzip reports whether the client's Accept-Encoding header allows a
+// gzip-compressed response body.
+func acceptsGzip(c *gin.Context) bool {
+	return strings.Contains(c.GetHeader("Accept-Encoding"), "gzip")
+}
+
+// negotiateFormat picks a target image format from the ?format= query
+// param, falling back to the client's Accept header. Returns "" if neither
+// names a format, meaning "serve as stored".
+func negotiateFormat(c *gin.Context) string {
+	if format := c.Query("format"); format != "" {
+		return format
+	}
+	accept := c.GetHeader("Accept")
+	for _, candidate := range []string{"webp", "avif", "png", "jpeg"} {
+		if strings.Contains(accept, "image/"+candidate) {
+			return candidate
+		}
+	}
+	return ""
+}
+
+// identityTransform reports whether opts would return the asset exactly as
+// stored, with no resize/transcode/archive-extraction changing its bytes
+// (gzip re-encodes the transfer, not the entity, so it doesn't count).
+func identityTransform(opts LoadOptions) bool {
+	return opts.Width == 0 && opts.Height == 0 && opts.Format == "" && opts.ArchivePath == ""
+}
+
+// etagFromFilename returns a strong ETag derived from filename's
+// content-hash prefix, or "" if filename doesn't look content-addressed
+// (e.g. it predates that convention).
+func etagFromFilename(filename string) string {
+	name := strings.TrimSuffix(filename, filepath.Ext(filename))
+	if !contentHashPattern.MatchString(name) {
+		return ""
+	}
+	return `"` + name + `"`
+}
+
+// strongETag derives a strong ETag from the SHA-256 of data.
+func strongETag(data []byte) string {
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+// handleCacheStats reports the resize cache's current entry count and size.
+func handleCacheStats(c *gin.Context) {
+	c.JSON(http.StatusOK, diskCache.Stats())
+}
 
-	// Resized image
-	c.File(dstPath)
+// handleCachePurge empties the resize cache, removing every cached file.
+func handleCachePurge(c *gin.Context) {
+	diskCache.Purge()
+	c.JSON(http.StatusOK, gin.H{"message": "Cache purged"})
 }
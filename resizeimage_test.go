@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// countLoader wraps an AssetLoader and counts how many times Load actually
+// ran, so a test can tell whether resizeGroup collapsed concurrent requests
+// for the same cacheKey into a single underlying resize.
+type countLoader struct {
+	next AssetLoader
+	n    int32
+}
+
+func (l *countLoader) Load(ctx context.Context, name string, opts LoadOptions) (io.ReadCloser, Meta, error) {
+	atomic.AddInt32(&l.n, 1)
+	return l.next.Load(ctx, name, opts)
+}
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// TestResizeImageSingleflightAndDimensions guards against two historical
+// bugs in resizeImage/resizeGroup: concurrent requests for the same resize
+// used to race and redo the decode/resize/write independently instead of
+// sharing one result, and the resized output used to be hardcoded to
+// 300x300 regardless of the requested width/height.
+func TestResizeImageSingleflightAndDimensions(t *testing.T) {
+	dir := t.TempDir()
+	store, err := newLocalStorage(filepath.Join(dir, "uploads"))
+	if err != nil {
+		t.Fatalf("newLocalStorage: %v", err)
+	}
+	cache, err := NewDiskCache(filepath.Join(dir, "cache"), defaultCacheMaxSize, defaultCacheMaxAge)
+	if err != nil {
+		t.Fatalf("NewDiskCache: %v", err)
+	}
+
+	counter := &countLoader{next: NewStoreLoader(store)}
+	loader := NewGzipLoader(NewImageLoader(NewArchiveLoader(counter), nil, defaultMaxSourcePixels, defaultMaxOutputPixels))
+
+	origStorage, origCache, origLoader := uploadStorage, diskCache, assetLoader
+	uploadStorage, diskCache, assetLoader = store, cache, loader
+	t.Cleanup(func() { uploadStorage, diskCache, assetLoader = origStorage, origCache, origLoader })
+
+	// resizeGroup is a shared global, so a filename unique to this test
+	// guarantees its cacheKey can't collide with another test's in-flight
+	// (or already-forgotten) singleflight key.
+	filename := "source-singleflight-test.png"
+	data := testPNG(t, 100, 80)
+	if err := store.Put(context.Background(), filename, bytes.NewReader(data), int64(len(data))); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	const concurrency = 20
+	var wg sync.WaitGroup
+	recorders := make([]*httptest.ResponseRecorder, concurrency)
+	wg.Add(concurrency)
+	for i := 0; i < concurrency; i++ {
+		go func(i int) {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodGet, "/api/resize/"+filename+"?width=50&height=60", nil)
+			c.Params = gin.Params{{Key: "filename", Value: filename}}
+			resizeImage(c)
+			recorders[i] = w
+		}(i)
+	}
+	wg.Wait()
+
+	for i, w := range recorders {
+		if w.Code != http.StatusOK {
+			t.Fatalf("request %d: status = %d, want 200; body: %s", i, w.Code, w.Body.String())
+		}
+		cfg, _, err := image.DecodeConfig(bytes.NewReader(w.Body.Bytes()))
+		if err != nil {
+			t.Fatalf("request %d: decoding response image: %v", i, err)
+		}
+		if cfg.Width != 50 || cfg.Height != 60 {
+			t.Errorf("request %d: resized to %dx%d, want 50x60", i, cfg.Width, cfg.Height)
+		}
+	}
+
+	if got := atomic.LoadInt32(&counter.n); got != 1 {
+		t.Errorf("underlying loader ran %d times for %d concurrent identical requests, want exactly 1 (resizeGroup should single-flight them)", got, concurrency)
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// errResizerSaturated is returned when the external resizer's concurrency
+// semaphore is full; handlers translate it to HTTP 429.
+var errResizerSaturated = errors.New("external resizer is saturated")
+
+// Defaults for RESIZER_MAX_SOURCE_PIXELS / RESIZER_MAX_OUTPUT_PIXELS. These
+// bound every resize, not just ones routed through the external resizer
+// subprocess: RESIZER_BINARY is opt-in, and a hostile width/height query is
+// exactly as dangerous decoded in the Gin server's own process as it is in a
+// sandboxed subprocess.
+const (
+	defaultMaxOutputPixels = 64_000_000
+	defaultMaxSourcePixels = 500_000_000
+)
+
+// resizeLimitsFromEnv reads RESIZER_MAX_OUTPUT_PIXELS and
+// RESIZER_MAX_SOURCE_PIXELS, defaulting either that's unset. ImageLoader
+// enforces these limits on the in-process resize path, and
+// newExternalResizerFromEnv passes them to the resizer subprocess, so the
+// same bound applies regardless of which path handles a given resize.
+func resizeLimitsFromEnv() (maxOutputPixels, maxSourcePixels int64, err error) {
+	maxOutputPixels = defaultMaxOutputPixels
+	if v := os.Getenv("RESIZER_MAX_OUTPUT_PIXELS"); v != "" {
+		parsed, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid RESIZER_MAX_OUTPUT_PIXELS %q: %w", v, err)
+		}
+		maxOutputPixels = parsed
+	}
+
+	maxSourcePixels = defaultMaxSourcePixels
+	if v := os.Getenv("RESIZER_MAX_SOURCE_PIXELS"); v != "" {
+		parsed, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid RESIZER_MAX_SOURCE_PIXELS %q: %w", v, err)
+		}
+		maxSourcePixels = parsed
+	}
+
+	return maxOutputPixels, maxSourcePixels, nil
+}
+
+var (
+	resizeDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name: "resize_duration_seconds",
+		Help: "Time spent resizing an image in the external resizer subprocess.",
+	})
+	resizeErrorsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "resize_errors_total",
+		Help: "Total number of external resizer failures.",
+	})
+	resizeInflight = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "resize_inflight",
+		Help: "Number of external resizer subprocesses currently running.",
+	})
+)
+
+// ExternalResizer runs image resizes in a short-lived subprocess so a
+// decoder crash or OOM on an adversarial image can't take down the server,
+// and so operators can cap how much CPU untrusted uploads are allowed to
+// spend on resizing.
+type ExternalResizer struct {
+	binaryPath      string
+	timeout         time.Duration
+	maxInputBytes   int64
+	maxOutputPixels int64
+	maxSourcePixels int64
+	sem             chan struct{}
+}
+
+// newExternalResizerFromEnv builds an ExternalResizer from RESIZER_* env
+// vars, or returns (nil, nil) if RESIZER_BINARY is unset, in which case
+// resizing stays in-process.
+func newExternalResizerFromEnv() (*ExternalResizer, error) {
+	binaryPath := os.Getenv("RESIZER_BINARY")
+	if binaryPath == "" {
+		return nil, nil
+	}
+
+	timeout := 10 * time.Second
+	if v := os.Getenv("RESIZER_TIMEOUT"); v != "" {
+		parsed, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RESIZER_TIMEOUT %q: %w", v, err)
+		}
+		timeout = parsed
+	}
+
+	maxInputBytes := int64(32 << 20) // 32 MiB
+	if v := os.Getenv("RESIZER_MAX_INPUT_BYTES"); v != "" {
+		parsed, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RESIZER_MAX_INPUT_BYTES %q: %w", v, err)
+		}
+		maxInputBytes = parsed
+	}
+
+	maxOutputPixels, maxSourcePixels, err := resizeLimitsFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	concurrency := 4
+	if v := os.Getenv("RESIZER_MAX_CONCURRENCY"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RESIZER_MAX_CONCURRENCY %q: %w", v, err)
+		}
+		concurrency = parsed
+	}
+
+	return &ExternalResizer{
+		binaryPath:      binaryPath,
+		timeout:         timeout,
+		maxInputBytes:   maxInputBytes,
+		maxOutputPixels: maxOutputPixels,
+		maxSourcePixels: maxSourcePixels,
+		sem:             make(chan struct{}, concurrency),
+	}, nil
+}
+
+// Resize runs the external resizer subprocess against src, returning the
+// re-encoded image bytes. It returns errResizerSaturated immediately,
+// without spawning a process, once RESIZER_MAX_CONCURRENCY resizes are
+// already in flight.
+func (r *ExternalResizer) Resize(ctx context.Context, src io.Reader, width, height int, format string) ([]byte, error) {
+	select {
+	case r.sem <- struct{}{}:
+	default:
+		return nil, errResizerSaturated
+	}
+	defer func() { <-r.sem }()
+
+	resizeInflight.Inc()
+	defer resizeInflight.Dec()
+
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	start := time.Now()
+	data, err := r.run(ctx, src, width, height, format)
+	resizeDuration.Observe(time.Since(start).Seconds())
+	if err != nil {
+		resizeErrorsTotal.Inc()
+	}
+	return data, err
+}
+
+func (r *ExternalResizer) run(ctx context.Context, src io.Reader, width, height int, format string) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(src, r.maxInputBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("reading source image: %w", err)
+	}
+	if int64(len(data)) > r.maxInputBytes {
+		return nil, fmt.Errorf("source image exceeds the %d byte limit", r.maxInputBytes)
+	}
+
+	cmd := exec.CommandContext(ctx, r.binaryPath,
+		"-width", strconv.Itoa(width),
+		"-height", strconv.Itoa(height),
+		"-format", format,
+		"-max-output-pixels", strconv.FormatInt(r.maxOutputPixels, 10),
+		"-max-source-pixels", strconv.FormatInt(r.maxSourcePixels, 10),
+	)
+	cmd.Stdin = bytes.NewReader(data)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("resizer timed out after %s", r.timeout)
+		}
+		return nil, fmt.Errorf("resizer failed: %w: %s", err, stderr.String())
+	}
+	if stdout.Len() == 0 {
+		return nil, fmt.Errorf("resizer produced no output")
+	}
+	return stdout.Bytes(), nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// buildResizerBinary compiles cmd/resizer into t.TempDir() so tests can
+// exercise ExternalResizer.Resize against the real subprocess instead of
+// mocking exec.Command. Skips the test if the go toolchain isn't available
+// in this environment.
+func buildResizerBinary(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go toolchain not available to build cmd/resizer")
+	}
+	bin := filepath.Join(t.TempDir(), "resizer")
+	cmd := exec.Command("go", "build", "-o", bin, "./cmd/resizer")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("building cmd/resizer: %v\n%s", err, out)
+	}
+	return bin
+}
+
+func testPNG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{R: 10, G: 20, B: 30, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("encoding test image: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestExternalResizerResize(t *testing.T) {
+	bin := buildResizerBinary(t)
+	r := &ExternalResizer{
+		binaryPath:      bin,
+		timeout:         5 * time.Second,
+		maxInputBytes:   32 << 20,
+		maxOutputPixels: 64_000_000,
+		maxSourcePixels: 500_000_000,
+		sem:             make(chan struct{}, 4),
+	}
+
+	data, err := r.Resize(context.Background(), bytes.NewReader(testPNG(t, 20, 20)), 10, 10, "png")
+	if err != nil {
+		t.Fatalf("Resize: %v", err)
+	}
+	if len(data) == 0 {
+		t.Fatal("Resize: expected non-empty output")
+	}
+}
+
+func TestExternalResizerResizeTimeout(t *testing.T) {
+	// A binary that always sleeps past its deadline stands in for a
+	// pathological resize, so the test doesn't depend on crafting an input
+	// that's slow to decode/resize for real.
+	bin := filepath.Join(t.TempDir(), "slow-resizer")
+	cmd := exec.Command("go", "build", "-o", bin, "./testdata/slowresizer")
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go toolchain not available to build testdata/slowresizer")
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("building testdata/slowresizer: %v\n%s", err, out)
+	}
+
+	r := &ExternalResizer{
+		binaryPath:      bin,
+		timeout:         50 * time.Millisecond,
+		maxInputBytes:   32 << 20,
+		maxOutputPixels: 64_000_000,
+		maxSourcePixels: 500_000_000,
+		sem:             make(chan struct{}, 4),
+	}
+
+	_, err := r.Resize(context.Background(), bytes.NewReader([]byte("irrelevant")), 10, 10, "png")
+	if err == nil {
+		t.Fatal("Resize: expected a timeout error")
+	}
+}
+
+// TestExternalResizerResizeSaturated checks that Resize returns
+// errResizerSaturated, without spawning a process, once RESIZER_MAX_CONCURRENCY
+// resizes are already in flight.
+func TestExternalResizerResizeSaturated(t *testing.T) {
+	r := &ExternalResizer{
+		binaryPath:      "/bin/does-not-matter",
+		timeout:         time.Second,
+		maxInputBytes:   32 << 20,
+		maxOutputPixels: 64_000_000,
+		maxSourcePixels: 500_000_000,
+		sem:             make(chan struct{}, 2),
+	}
+
+	// Fill the semaphore directly, simulating 2 in-flight resizes, without
+	// needing real concurrent subprocesses.
+	r.sem <- struct{}{}
+	r.sem <- struct{}{}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 1)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_, err := r.Resize(context.Background(), bytes.NewReader(nil), 10, 10, "png")
+		errs <- err
+	}()
+	wg.Wait()
+
+	if err := <-errs; !errors.Is(err, errResizerSaturated) {
+		t.Fatalf("Resize: got err %v, want errResizerSaturated", err)
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// ObjectInfo describes a stored object, mirroring the subset of os.FileInfo
+// that handlers actually need regardless of which backend produced it.
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	ModTime      time.Time
+	LastAccessed time.Time
+}
+
+// Storage abstracts the persistence layer used for uploaded and processed
+// images so that handlers never touch os/filepath directly. Implementations
+// must be safe for concurrent use.
+type Storage interface {
+	// Put stores the contents of r under key, overwriting any existing object.
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+	// Get returns a reader for the object stored under key. The caller must
+	// close it. Returns os.ErrNotExist (or an error wrapping it) if key is absent.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Stat returns metadata about the object stored under key.
+	Stat(ctx context.Context, key string) (ObjectInfo, error)
+	// Delete removes the object stored under key. It is not an error to
+	// delete a key that does not exist.
+	Delete(ctx context.Context, key string) error
+	// URL returns the public URL at which key can be fetched by clients.
+	URL(key string) string
+}
+
+// newStorageFromEnv builds the Storage backend selected by the
+// STORAGE_BACKEND env var ("local" or "s3"), defaulting to "local". dir is
+// used as the root directory for the local backend; prefix is used as the
+// object key prefix for the S3 backend, so uploads and cached renditions can
+// share one bucket without colliding.
+func newStorageFromEnv(dir, prefix string) (Storage, error) {
+	switch backend := os.Getenv("STORAGE_BACKEND"); backend {
+	case "", "local":
+		return newLocalStorage(dir)
+	case "s3":
+		return newS3StorageFromEnv(prefix)
+	default:
+		return nil, fmt.Errorf("unknown STORAGE_BACKEND %q", backend)
+	}
+}
+
+// LocalStorage stores objects as files under a root directory on disk.
+type LocalStorage struct {
+	dir     string
+	baseURL string
+}
+
+// newLocalStorage creates a LocalStorage rooted at dir, creating it if needed.
+func newLocalStorage(dir string) (*LocalStorage, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("creating storage dir %s: %w", dir, err)
+	}
+	baseURL := os.Getenv("PUBLIC_BASE_URL")
+	if baseURL == "" {
+		baseURL = "https://cdn.nakamastream.lat"
+	}
+	return &LocalStorage{dir: dir, baseURL: baseURL}, nil
+}
+
+func (s *LocalStorage) path(key string) string {
+	return filepath.Join(s.dir, key)
+}
+
+func (s *LocalStorage) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	f, err := os.Create(s.path(key))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(f, r)
+	return err
+}
+
+func (s *LocalStorage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	return os.Open(s.path(key))
+}
+
+func (s *LocalStorage) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	fi, err := os.Stat(s.path(key))
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	return ObjectInfo{Key: key, Size: fi.Size(), ModTime: fi.ModTime()}, nil
+}
+
+func (s *LocalStorage) Delete(ctx context.Context, key string) error {
+	err := os.Remove(s.path(key))
+	if err != nil && os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (s *LocalStorage) URL(key string) string {
+	return s.baseURL + "/api/image/" + key
+}
+
+// S3Storage stores objects in an S3-compatible object store (AWS S3, MinIO,
+// or anything else speaking the S3 API).
+type S3Storage struct {
+	client    *minio.Client
+	bucket    string
+	keyPrefix string
+	baseURL   string
+}
+
+// newS3StorageFromEnv builds an S3Storage from S3_* environment variables:
+//   - S3_ENDPOINT (required, e.g. "s3.amazonaws.com" or "minio.local:9000")
+//   - S3_BUCKET (required)
+//   - S3_ACCESS_KEY, S3_SECRET_KEY (required)
+//   - S3_USE_SSL (optional, default "true")
+//   - S3_REGION (optional)
+//   - PUBLIC_BASE_URL (optional, overrides the URL returned to clients)
+//
+// keyPrefix namespaces object keys within the shared bucket (e.g. "uploads/"
+// vs "cache/") so callers storing different kinds of data don't collide.
+func newS3StorageFromEnv(keyPrefix string) (*S3Storage, error) {
+	endpoint := os.Getenv("S3_ENDPOINT")
+	bucket := os.Getenv("S3_BUCKET")
+	accessKey := os.Getenv("S3_ACCESS_KEY")
+	secretKey := os.Getenv("S3_SECRET_KEY")
+	if endpoint == "" || bucket == "" || accessKey == "" || secretKey == "" {
+		return nil, fmt.Errorf("S3_ENDPOINT, S3_BUCKET, S3_ACCESS_KEY and S3_SECRET_KEY are required for STORAGE_BACKEND=s3")
+	}
+
+	useSSL := true
+	if v := os.Getenv("S3_USE_SSL"); v != "" {
+		parsed, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid S3_USE_SSL %q: %w", v, err)
+		}
+		useSSL = parsed
+	}
+
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(accessKey, secretKey, ""),
+		Secure: useSSL,
+		Region: os.Getenv("S3_REGION"),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("creating S3 client: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	exists, err := client.BucketExists(ctx, bucket)
+	if err != nil {
+		return nil, fmt.Errorf("checking bucket %s: %w", bucket, err)
+	}
+	if !exists {
+		if err := client.MakeBucket(ctx, bucket, minio.MakeBucketOptions{Region: os.Getenv("S3_REGION")}); err != nil {
+			return nil, fmt.Errorf("creating bucket %s: %w", bucket, err)
+		}
+		log.Printf("Created S3 bucket %s", bucket)
+	}
+
+	baseURL := os.Getenv("PUBLIC_BASE_URL")
+	if baseURL == "" {
+		scheme := "https"
+		if !useSSL {
+			scheme = "http"
+		}
+		baseURL = fmt.Sprintf("%s://%s/%s", scheme, endpoint, bucket)
+	}
+
+	return &S3Storage{client: client, bucket: bucket, keyPrefix: keyPrefix, baseURL: baseURL}, nil
+}
+
+func (s *S3Storage) objectKey(key string) string {
+	return s.keyPrefix + key
+}
+
+func (s *S3Storage) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	_, err := s.client.PutObject(ctx, s.bucket, s.objectKey(key), r, size, minio.PutObjectOptions{})
+	return err
+}
+
+func (s *S3Storage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	obj, err := s.client.GetObject(ctx, s.bucket, s.objectKey(key), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	// GetObject succeeds even for a missing key; Stat is what surfaces the
+	// error, so check eagerly rather than failing on the first Read.
+	if _, err := obj.Stat(); err != nil {
+		obj.Close()
+		return nil, err
+	}
+	return obj, nil
+}
+
+func (s *S3Storage) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	info, err := s.client.StatObject(ctx, s.bucket, s.objectKey(key), minio.StatObjectOptions{})
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	return ObjectInfo{Key: key, Size: info.Size, ModTime: info.LastModified}, nil
+}
+
+func (s *S3Storage) Delete(ctx context.Context, key string) error {
+	return s.client.RemoveObject(ctx, s.bucket, s.objectKey(key), minio.RemoveObjectOptions{})
+}
+
+func (s *S3Storage) URL(key string) string {
+	return s.baseURL + "/" + s.objectKey(key)
+}
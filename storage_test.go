@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestLocalStorageRoundTrip(t *testing.T) {
+	s, err := newLocalStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("newLocalStorage: %v", err)
+	}
+	ctx := context.Background()
+
+	if err := s.Put(ctx, "a.png", bytes.NewReader([]byte("hello")), 5); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	info, err := s.Stat(ctx, "a.png")
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if info.Size != 5 {
+		t.Errorf("Stat.Size = %d, want 5", info.Size)
+	}
+
+	rc, err := s.Get(ctx, "a.png")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	data, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		t.Fatalf("reading Get result: %v", err)
+	}
+	if string(data) != "hello" {
+		t.Errorf("Get data = %q, want %q", data, "hello")
+	}
+
+	if got := s.URL("a.png"); got == "" {
+		t.Error("URL: expected non-empty URL")
+	}
+
+	if err := s.Delete(ctx, "a.png"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := s.Stat(ctx, "a.png"); !os.IsNotExist(err) {
+		t.Errorf("Stat after Delete: err = %v, want os.ErrNotExist", err)
+	}
+
+	// Deleting an absent key is a no-op, not an error.
+	if err := s.Delete(ctx, "never-existed.png"); err != nil {
+		t.Errorf("Delete of missing key: %v, want nil", err)
+	}
+}
+
+func TestLocalStorageGetMissing(t *testing.T) {
+	s, err := newLocalStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("newLocalStorage: %v", err)
+	}
+	if _, err := s.Get(context.Background(), "missing.png"); !os.IsNotExist(err) {
+		t.Errorf("Get(missing): err = %v, want os.ErrNotExist", err)
+	}
+}
+
+func TestNewStorageFromEnvUnknownBackend(t *testing.T) {
+	t.Setenv("STORAGE_BACKEND", "azure-blob")
+	if _, err := newStorageFromEnv(t.TempDir(), "uploads/"); err == nil {
+		t.Error("newStorageFromEnv: expected an error for an unknown backend")
+	}
+}
+
+func TestNewStorageFromEnvDefaultsToLocal(t *testing.T) {
+	t.Setenv("STORAGE_BACKEND", "")
+	store, err := newStorageFromEnv(t.TempDir(), "uploads/")
+	if err != nil {
+		t.Fatalf("newStorageFromEnv: %v", err)
+	}
+	if _, ok := store.(*LocalStorage); !ok {
+		t.Errorf("newStorageFromEnv: got %T, want *LocalStorage", store)
+	}
+}
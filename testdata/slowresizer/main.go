@@ -0,0 +1,11 @@
+// Command slowresizer is a test double for cmd/resizer that ignores its
+// flags and sleeps, standing in for a resize that hangs past its deadline so
+// resizer_test.go can exercise ExternalResizer's timeout handling without
+// crafting an input that's genuinely slow to decode/resize.
+package main
+
+import "time"
+
+func main() {
+	time.Sleep(time.Hour)
+}
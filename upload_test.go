@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"image/jpeg"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newUploadRequest builds a multipart/form-data POST request carrying data
+// under the "image" field, the same field name handleImageUpload reads via
+// c.FormFile.
+func newUploadRequest(t *testing.T, filename string, data []byte) *http.Request {
+	t.Helper()
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	part, err := w.CreateFormFile("image", filename)
+	if err != nil {
+		t.Fatalf("CreateFormFile: %v", err)
+	}
+	if _, err := part.Write(data); err != nil {
+		t.Fatalf("writing multipart body: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("closing multipart writer: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/api/upload", &buf)
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	return req
+}
+
+func setupUploadTest(t *testing.T) {
+	t.Helper()
+	store, err := newLocalStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("newLocalStorage: %v", err)
+	}
+	cache, err := NewDiskCache(t.TempDir(), defaultCacheMaxSize, defaultCacheMaxAge)
+	if err != nil {
+		t.Fatalf("NewDiskCache: %v", err)
+	}
+	loader := NewGzipLoader(NewImageLoader(NewArchiveLoader(NewStoreLoader(store)), nil, defaultMaxSourcePixels, defaultMaxOutputPixels))
+
+	origStorage, origCache, origLoader := uploadStorage, diskCache, assetLoader
+	uploadStorage, diskCache, assetLoader = store, cache, loader
+	t.Cleanup(func() { uploadStorage, diskCache, assetLoader = origStorage, origCache, origLoader })
+}
+
+func decodeUploadResponse(t *testing.T, w *httptest.ResponseRecorder) map[string]string {
+	t.Helper()
+	var resp map[string]string
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decoding response body %q: %v", w.Body.String(), err)
+	}
+	return resp
+}
+
+func TestHandleImageUploadDedupe(t *testing.T) {
+	setupUploadTest(t)
+	data := testPNG(t, 8, 8)
+
+	w1 := httptest.NewRecorder()
+	c1, _ := gin.CreateTestContext(w1)
+	c1.Request = newUploadRequest(t, "first.png", data)
+	handleImageUpload(c1)
+
+	if w1.Code != http.StatusOK {
+		t.Fatalf("first upload: status = %d, body = %s", w1.Code, w1.Body.String())
+	}
+	first := decodeUploadResponse(t, w1)
+	if first["message"] != "Image uploaded successfully" {
+		t.Errorf("first upload message = %q, want %q", first["message"], "Image uploaded successfully")
+	}
+
+	// Uploading byte-identical content again (even under a different
+	// filename) should hit the content-hash dedupe path instead of writing
+	// a second copy.
+	w2 := httptest.NewRecorder()
+	c2, _ := gin.CreateTestContext(w2)
+	c2.Request = newUploadRequest(t, "second.png", data)
+	handleImageUpload(c2)
+
+	if w2.Code != http.StatusOK {
+		t.Fatalf("second upload: status = %d, body = %s", w2.Code, w2.Body.String())
+	}
+	second := decodeUploadResponse(t, w2)
+	if second["message"] != "Image already exists" {
+		t.Errorf("second upload message = %q, want %q", second["message"], "Image already exists")
+	}
+	if second["filename"] != first["filename"] {
+		t.Errorf("second upload filename = %q, want it to match the first upload's %q", second["filename"], first["filename"])
+	}
+}
+
+func TestHandleImageUploadNoFile(t *testing.T) {
+	setupUploadTest(t)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/upload", bytes.NewReader(nil))
+	handleImageUpload(c)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestStripEXIFProducesDecodableJPEG(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 12, 9))
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatalf("encoding source JPEG: %v", err)
+	}
+
+	stripped, err := stripEXIF(buf.Bytes())
+	if err != nil {
+		t.Fatalf("stripEXIF: %v", err)
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(stripped))
+	if err != nil {
+		t.Fatalf("decoding stripped JPEG: %v", err)
+	}
+	if cfg.Width != 12 || cfg.Height != 9 {
+		t.Errorf("stripped image is %dx%d, want 12x9", cfg.Width, cfg.Height)
+	}
+}
+
+func TestStripEXIFInvalidJPEG(t *testing.T) {
+	if _, err := stripEXIF([]byte("not a jpeg")); err == nil {
+		t.Error("stripEXIF: expected an error for invalid JPEG data")
+	}
+}
+
+func TestHandleImageUploadStripsEXIFForJPEG(t *testing.T) {
+	setupUploadTest(t)
+
+	img := image.NewRGBA(image.Rect(0, 0, 6, 6))
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatalf("encoding source JPEG: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = newUploadRequest(t, "photo.jpg", buf.Bytes())
+	handleImageUpload(c)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+	}
+	resp := decodeUploadResponse(t, w)
+	filename := filepath.Base(resp["filename"])
+	stored, err := uploadStorage.Get(context.Background(), filename)
+	if err != nil {
+		t.Fatalf("fetching uploaded object: %v", err)
+	}
+	defer stored.Close()
+	cfg, _, err := image.DecodeConfig(stored)
+	if err != nil {
+		t.Fatalf("decoding stored JPEG: %v", err)
+	}
+	if cfg.Width != 6 || cfg.Height != 6 {
+		t.Errorf("stored image is %dx%d, want 6x6", cfg.Width, cfg.Height)
+	}
+}
+
+func TestServeImageRejectsUnsupportedFormat(t *testing.T) {
+	setupUploadTest(t)
+	data := testPNG(t, 4, 4)
+	if err := uploadStorage.Put(context.Background(), "source.png", bytes.NewReader(data), int64(len(data))); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/api/image/source.png?format=bogus", nil)
+	c.Params = gin.Params{{Key: "filename", Value: "source.png"}}
+	serveImage(c)
+
+	if w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusUnsupportedMediaType)
+	}
+}
+
+func TestResizeImageRejectsUnsupportedFormat(t *testing.T) {
+	setupUploadTest(t)
+	data := testPNG(t, 4, 4)
+	if err := uploadStorage.Put(context.Background(), "source.png", bytes.NewReader(data), int64(len(data))); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/api/resize/source.png?width=10&height=10&format=bogus", nil)
+	c.Params = gin.Params{{Key: "filename", Value: "source.png"}}
+	resizeImage(c)
+
+	if w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusUnsupportedMediaType)
+	}
+}